@@ -1,71 +1,301 @@
 package actionlint
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os/exec"
+	"regexp"
+	"sync"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sync/semaphore"
 )
 
+// Pool names used for the external tools actionlint spawns. Rules request a budget by this name
+// so a large shellcheck run doesn't starve a quick pyflakes run or vice versa.
+const (
+	shellcheckPoolName = "shellcheck"
+	pyflakesPoolName   = "pyflakes"
+)
+
+// defaultMaxOutputBytes bounds how much stdout a single process invocation may produce before
+// concurrentProcess gives up on it and kills it, so a misbehaving linter can't OOM actionlint.
+const defaultMaxOutputBytes = 10 * 1024 * 1024 // 10MiB
+
+// maxTransientRetries is the number of times a call classified as transient by a
+// concurrentProcess's isTransient predicate is retried before giving up.
+const maxTransientRetries = 3
+
+// retryBackoffBase is the base delay used for the exponential backoff between retries of a
+// transient failure. The first retry runs right away; each later retry waits twice as long as
+// the one before it, starting at retryBackoffBase (200ms, then 400ms).
+const retryBackoffBase = 200 * time.Millisecond
+
+// transientErrorPattern matches the known-flaky failures mentioned in the concurrentProcess doc
+// comment: fork/exec failures and file descriptor exhaustion seen on macOS and in CI containers
+// with low FD limits (mirrors the approach taken by golang.org/x/tools' gocommand.Runner).
+var transientErrorPattern = regexp.MustCompile(`(?i)(resource temporarily unavailable|too many (open )?files|cannot allocate memory)`)
+
+// isTransientProcessError is the default transient-error predicate installed on a new
+// concurrentProcess. It can be overridden with setTransientErrorChecker.
+func isTransientProcessError(stdout []byte, err error) bool {
+	return err != nil && transientErrorPattern.MatchString(err.Error())
+}
+
 // concurrentProcess is a manager to run process concurrently. Since running process consumes OS
 // resources, running too many processes concurrently causes some issues. On macOS, making new
 // process hangs (see issue #3). And also running processes which opens files causes an error
 // "pipe: too many files to open". To avoid it, this class manages how many processes are run at
 // the same time.
+//
+// Budgets are tracked per named pool (see processPool) rather than with one global limit, so a
+// heavy shellcheck run on a large script can't starve a quick pyflakes run, or vice versa. Pools
+// that aren't explicitly configured fall back to defaultJobs slots.
+//
+// It also owns a cancelable context so an in-flight run can be aborted (e.g. on Ctrl-C or when
+// a fail-fast condition is hit) and an optional per-invocation timeout so a single hung child
+// process cannot block the whole run forever.
 type concurrentProcess struct {
-	ctx  context.Context
+	ctx         context.Context
+	cancel      context.CancelFunc
+	poolsMu     sync.Mutex
+	pools       map[string]*processPool
+	defaultJobs int
+	workerMu    sync.Mutex
+	workerPools map[string]*workerPool
+	serialSema  *semaphore.Weighted
+	eg          errgroup.Group
+	timeout     time.Duration
+	isTransient func(stdout []byte, err error) bool
+	maxOutput   int64
+	cache       *resultCache
+}
+
+// processPool is a named budget of concurrency within a concurrentProcess. All calls made
+// through the same pool share its weighted semaphore, independently of every other pool.
+type processPool struct {
+	name string
 	sema *semaphore.Weighted
-	eg   errgroup.Group
+	proc *concurrentProcess
+}
+
+// newConcurrentProcess creates a new concurrentProcess instance. The given context is used as the
+// parent context for all processes spawned by it, so canceling it stops accepting new work and
+// terminates outstanding children. poolJobs configures the number of concurrency slots for
+// specific pool names (e.g. shellcheckPoolName); any pool not listed there falls back to
+// defaultJobs slots, lazily created on first use. When timeout is greater than zero, it is
+// applied to each individual process invocation.
+//
+// poolJobs is the mechanism the CLI's `-shellcheck-jobs`/`-pyflakes-jobs`/`-jobs` flags and
+// RuleShellcheck/RulePyflakes are expected to build on to request budget by pool name; that flag
+// parsing and rule-side wiring lives in cmd/ and the rule files, neither of which exist in this
+// slice of the repository, so it isn't done here.
+func newConcurrentProcess(ctx context.Context, defaultJobs int, poolJobs map[string]int, timeout time.Duration) *concurrentProcess {
+	ctx, cancel := context.WithCancel(ctx)
+	proc := &concurrentProcess{
+		ctx:         ctx,
+		cancel:      cancel,
+		pools:       make(map[string]*processPool, len(poolJobs)),
+		defaultJobs: defaultJobs,
+		serialSema:  semaphore.NewWeighted(1),
+		timeout:     timeout,
+		isTransient: isTransientProcessError,
+		maxOutput:   defaultMaxOutputBytes,
+	}
+	for name, jobs := range poolJobs {
+		proc.pools[name] = newProcessPool(proc, name, jobs)
+	}
+	return proc
 }
 
-func newConcurrentProcess(par int) *concurrentProcess {
-	return &concurrentProcess{
-		ctx:  context.Background(),
-		sema: semaphore.NewWeighted(int64(par)),
+func newProcessPool(proc *concurrentProcess, name string, jobs int) *processPool {
+	return &processPool{
+		name: name,
+		sema: semaphore.NewWeighted(int64(jobs)),
+		proc: proc,
 	}
 }
 
-func runProcessWithStdin(exe string, args []string, stdin string) ([]byte, error) {
-	cmd := exec.Command(exe, args...)
-	cmd.Stderr = nil
+// pool returns the named pool, creating it with defaultJobs slots on first use if it wasn't
+// configured explicitly via poolJobs at construction time.
+func (proc *concurrentProcess) pool(name string) *processPool {
+	proc.poolsMu.Lock()
+	defer proc.poolsMu.Unlock()
+	if p, ok := proc.pools[name]; ok {
+		return p
+	}
+	p := newProcessPool(proc, name, proc.defaultJobs)
+	proc.pools[name] = p
+	return p
+}
+
+// setTransientErrorChecker overrides the predicate used to classify a failed call as transient.
+// A transient failure is re-run through a dedicated width-1 semaphore so it executes strictly
+// serially, with a bounded number of retries and exponential backoff, instead of being reported
+// to the caller immediately.
+func (proc *concurrentProcess) setTransientErrorChecker(isTransient func(stdout []byte, err error) bool) {
+	proc.isTransient = isTransient
+}
+
+// setMaxOutputBytes overrides how much stdout a single process invocation may produce before it
+// is killed. A value <= 0 restores defaultMaxOutputBytes.
+func (proc *concurrentProcess) setMaxOutputBytes(n int64) {
+	if n <= 0 {
+		n = defaultMaxOutputBytes
+	}
+	proc.maxOutput = n
+}
 
-	p, err := cmd.StdinPipe()
+// runProcessWithStdin starts exe, streams stdin to it and reads stdout concurrently so that
+// neither side can deadlock on a full pipe buffer, and caps the amount of stdout that is
+// buffered in memory at maxOutputBytes. If exe produces more than that, it is killed and an
+// error is returned instead of letting the output grow without bound.
+func runProcessWithStdin(ctx context.Context, exe string, args []string, stdin string, maxOutputBytes int64) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, exe, args...)
+
+	stdinPipe, err := cmd.StdinPipe()
 	if err != nil {
 		return nil, fmt.Errorf("could not make stdin pipe for %s process: %w", exe, err)
 	}
-	if _, err := io.WriteString(p, stdin); err != nil {
-		p.Close()
-		return nil, fmt.Errorf("could not write to stdin of %s process: %w", exe, err)
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not make stdout pipe for %s process: %w", exe, err)
 	}
-	p.Close()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
 
-	stdout, err := cmd.Output()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start %s process: %w", exe, err)
+	}
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		defer stdinPipe.Close()
+		_, err := io.WriteString(stdinPipe, stdin)
+		return err
+	})
+
+	var stdout bytes.Buffer
+	var truncated bool
+	eg.Go(func() error {
+		n, err := io.Copy(&stdout, io.LimitReader(stdoutPipe, maxOutputBytes+1))
+		if n > maxOutputBytes {
+			truncated = true
+			// The process may still be trying to write more. It has nothing left to read
+			// from, so kill it rather than let cmd.Wait() below block forever.
+			cmd.Process.Kill()
+		}
+		return err
+	})
+	writeErr := eg.Wait()
+	waitErr := cmd.Wait()
+
+	out := stdout.Bytes()
+	if truncated {
+		return out, fmt.Errorf("%s produced more than %d bytes of output and was killed to avoid exhausting memory", exe, maxOutputBytes)
+	}
+
+	if waitErr != nil {
+		if exitErr, ok := waitErr.(*exec.ExitError); ok {
 			code := exitErr.ExitCode()
 			if code < 0 {
-				return nil, fmt.Errorf("%s was terminated. stderr: %q", exe, exitErr.Stderr)
+				return nil, fmt.Errorf("%s was terminated. stderr: %q", exe, stderr.String())
 			}
-			if len(stdout) == 0 {
-				return nil, fmt.Errorf("%s exited with status %d but stdout was empty. stderr: %q", exe, code, exitErr.Stderr)
+			if len(out) == 0 {
+				return nil, fmt.Errorf("%s exited with status %d but stdout was empty. stderr: %q", exe, code, stderr.String())
 			}
 			// Reaches here when exit status is non-zero and stdout is not empty, shellcheck successfully found some errors
 		} else {
-			return nil, err
+			return nil, waitErr
 		}
+	} else if writeErr != nil {
+		return nil, fmt.Errorf("could not write to stdin of %s process: %w", exe, writeErr)
 	}
 
-	return stdout, nil
+	return out, nil
+}
+
+// runOnce runs exe a single time under proc's context and per-invocation timeout, if any.
+func (proc *concurrentProcess) runOnce(exe string, args []string, stdin string) ([]byte, error) {
+	ctx := proc.ctx
+	if proc.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, proc.timeout)
+		defer cancel()
+	}
+	return runProcessWithStdin(ctx, exe, args, stdin, proc.maxOutput)
 }
 
-func (proc *concurrentProcess) run(exe string, args []string, stdin string, callback func([]byte, error) error) {
-	proc.sema.Acquire(proc.ctx, 1)
+// runSerialWithRetry re-runs exe through proc.serialSema so it never overlaps with another
+// retried call, retrying up to maxTransientRetries times with exponential backoff as long as
+// proc.isTransient keeps classifying the failure as transient.
+func (proc *concurrentProcess) runSerialWithRetry(exe string, args []string, stdin string) ([]byte, error) {
+	var stdout []byte
+	var err error
+	for attempt := 0; attempt < maxTransientRetries; attempt++ {
+		if aerr := proc.serialSema.Acquire(proc.ctx, 1); aerr != nil {
+			return stdout, aerr
+		}
+		stdout, err = proc.runOnce(exe, args, stdin)
+		proc.serialSema.Release(1)
+		if !proc.isTransient(stdout, err) {
+			return stdout, err
+		}
+		if attempt == maxTransientRetries-1 {
+			// Last attempt: return the error now instead of waiting out a backoff nothing
+			// will observe.
+			break
+		}
+		backoff := retryBackoffBase * time.Duration(1<<attempt)
+		select {
+		case <-time.After(backoff):
+		case <-proc.ctx.Done():
+			return stdout, err
+		}
+	}
+	return stdout, err
+}
+
+// run runs exe through the named pool, acquiring a single slot from it. Use pool(name).runWeighted
+// directly when a call should consume more than one slot (e.g. a script known to be unusually
+// large). If proc has a result cache configured (see setCache) and it already has an entry for
+// (exe, args, stdin), callback is invoked synchronously with the cached result instead, without
+// acquiring a pool slot at all.
+func (proc *concurrentProcess) run(poolName string, exe string, args []string, stdin string, callback func([]byte, error) error) {
+	proc.runWithCache(exe, args, stdin, callback, func(cb func([]byte, error) error) {
+		proc.pool(poolName).run(exe, args, stdin, cb)
+	})
+}
+
+// run acquires one slot from p and runs exe, as concurrentProcess.run does.
+func (p *processPool) run(exe string, args []string, stdin string, callback func([]byte, error) error) {
+	p.runWeighted(exe, args, stdin, 1, callback)
+}
+
+// runWeighted acquires weight slots from p before running exe. Callers that know a particular
+// invocation is unusually expensive (e.g. a large shellcheck script) can pass a weight greater
+// than 1 so it counts against more of the pool's budget.
+func (p *processPool) runWeighted(exe string, args []string, stdin string, weight int64, callback func([]byte, error) error) {
+	proc := p.proc
+	if weight <= 0 {
+		weight = 1
+	}
+	if err := p.sema.Acquire(proc.ctx, weight); err != nil {
+		// proc.ctx was canceled before a slot was available. Don't start the process; report the
+		// cancellation to callback instead.
+		proc.eg.Go(func() error { return callback(nil, err) })
+		return
+	}
 	proc.eg.Go(func() error {
-		stdout, err := runProcessWithStdin(exe, args, stdin)
-		proc.sema.Release(1)
+		stdout, err := proc.runOnce(exe, args, stdin)
+		p.sema.Release(weight)
+		if proc.isTransient(stdout, err) {
+			// Fall back to strictly-serialized retries rather than surfacing a flaky failure.
+			stdout, err = proc.runSerialWithRetry(exe, args, stdin)
+		}
 		return callback(stdout, err)
 	})
 }
@@ -73,3 +303,17 @@ func (proc *concurrentProcess) run(exe string, args []string, stdin string, call
 func (proc *concurrentProcess) wait() error {
 	return proc.eg.Wait() // Wait for workers completing to shutdown
 }
+
+// Cancel stops accepting new work and terminates all outstanding child processes. It does not
+// wait for them to exit; call Shutdown if a blocking wait is needed.
+func (proc *concurrentProcess) Cancel() {
+	proc.cancel()
+}
+
+// Shutdown cancels the process and waits for all outstanding and already-queued processes to
+// finish (they exit early due to the cancellation). It returns the first error returned from
+// any of the callbacks, as wait() does.
+func (proc *concurrentProcess) Shutdown() error {
+	proc.cancel()
+	return proc.wait()
+}
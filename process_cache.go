@@ -0,0 +1,271 @@
+package actionlint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// cacheSchemaVersion is folded into every cache key. Bump it whenever the cached payload shape
+// changes, or whenever the set of tools/flags it captures changes in a way that could make a
+// stale entry misleading (e.g. upgrading the bundled shellcheck version).
+const cacheSchemaVersion = 1
+
+// cachedResult is the on-disk payload stored for one cache entry. Only successful runs are
+// cached (see store): a failure may be caused by something transient in the environment rather
+// than the script itself, and isn't safe to replay indefinitely for an unchanged input.
+type cachedResult struct {
+	Stdout []byte `json:"stdout"`
+}
+
+// resultCache is a content-addressed, on-disk cache of concurrentProcess run() results, keyed by
+// a hash of the executable identity, its arguments and its stdin. It lets repeated actionlint
+// invocations over an unchanged repository skip re-running shellcheck/pyflakes entirely.
+type resultCache struct {
+	dir     string
+	maxSize int64 // total bytes the cache directory may grow to before older entries are evicted; <= 0 means unbounded
+
+	sizeOnce sync.Once
+	sizeMu   sync.Mutex
+	size     int64 // running total of bytes on disk, seeded lazily by sizeOnce so evict() needn't re-walk the tree on every store
+}
+
+// defaultCacheDir returns $XDG_CACHE_HOME/actionlint, falling back to ~/.cache/actionlint when
+// XDG_CACHE_HOME is unset, matching the XDG base directory specification.
+func defaultCacheDir() (string, error) {
+	if d := os.Getenv("XDG_CACHE_HOME"); d != "" {
+		return filepath.Join(d, "actionlint"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine default cache directory: %w", err)
+	}
+	return filepath.Join(home, ".cache", "actionlint"), nil
+}
+
+func newResultCache(dir string, maxSize int64) *resultCache {
+	return &resultCache{dir: dir, maxSize: maxSize}
+}
+
+// cacheKey hashes everything that can affect exe's output: its own identity (path plus mtime and
+// size, so a rebuilt/upgraded shellcheck invalidates old entries), its arguments and its stdin.
+func cacheKey(exe string, args []string, stdin string) (string, error) {
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return "", fmt.Errorf("could not stat %s to compute cache key: %w", exe, err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "schema:%d\x00exe:%s\x00mtime:%d\x00size:%d\x00argc:%d\x00", cacheSchemaVersion, exe, fi.ModTime().UnixNano(), fi.Size(), len(args))
+	for _, a := range args {
+		fmt.Fprintf(h, "arg:%s\x00", a)
+	}
+	io.WriteString(h, stdin)
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// entryPath returns where key's entry lives on disk, sharded by its first two hex characters so
+// no single directory ends up with one file per cache entry.
+func (c *resultCache) entryPath(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// lookup returns the cached stdout for running exe with args and stdin, and whether it was
+// found. Only successful runs are ever cached, so a hit always means "no error". A hit bumps the
+// entry's mtime so the LRU-by-mtime eviction in store treats it as freshly used.
+func (c *resultCache) lookup(exe string, args []string, stdin string) (stdout []byte, ok bool) {
+	key, err := cacheKey(exe, args, stdin)
+	if err != nil {
+		return nil, false
+	}
+	path := c.entryPath(key)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var cr cachedResult
+	if err := json.Unmarshal(data, &cr); err != nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(path, now, now) // best-effort; a failed bump just makes this entry evict sooner than ideal
+
+	return cr.Stdout, true
+}
+
+// store writes the successful result of running exe with args and stdin to the cache, then
+// evicts the oldest entries (by mtime) if the cache directory has grown past maxSize. Failed
+// runs are never cached: an error may stem from something transient in the environment rather
+// than the script itself, and isn't safe to replay indefinitely for an unchanged input.
+func (c *resultCache) store(exe string, args []string, stdin string, stdout []byte, runErr error) {
+	if runErr != nil {
+		return
+	}
+
+	key, err := cacheKey(exe, args, stdin)
+	if err != nil {
+		return
+	}
+
+	data, err := json.Marshal(&cachedResult{Stdout: stdout})
+	if err != nil {
+		return
+	}
+
+	dir := filepath.Dir(c.entryPath(key))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), c.entryPath(key)); err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	c.addSize(int64(len(data)))
+}
+
+// addSize adds delta to the cache's tracked on-disk size (seeding it with one directory walk the
+// first time it's needed) and evicts the least-recently-used entries if that pushes the total
+// past maxSize. Tracking the running total avoids re-walking the whole cache directory on every
+// store call, which would otherwise make writes O(n) in the number of entries already cached.
+func (c *resultCache) addSize(delta int64) {
+	if c.maxSize <= 0 {
+		return
+	}
+
+	c.sizeOnce.Do(func() {
+		var total int64
+		filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+			return nil
+		})
+		c.sizeMu.Lock()
+		// The walk already counted the entry this call is adding; delta is added below.
+		c.size = total - delta
+		c.sizeMu.Unlock()
+	})
+
+	c.sizeMu.Lock()
+	c.size += delta
+	total := c.size
+	c.sizeMu.Unlock()
+
+	if total > c.maxSize {
+		c.evict()
+	}
+}
+
+// evict removes the least-recently-used entries until the cache directory's total size is back
+// at or under maxSize.
+func (c *resultCache) evict() {
+	type file struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var files []file
+	var total int64
+
+	filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		files = append(files, file{path, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	if total <= c.maxSize {
+		return
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+	var removed int64
+	for _, f := range files {
+		if total <= c.maxSize {
+			break
+		}
+		if os.Remove(f.path) == nil {
+			total -= f.size
+			removed += f.size
+		}
+	}
+
+	c.sizeMu.Lock()
+	c.size -= removed
+	c.sizeMu.Unlock()
+}
+
+// setCache points proc at an on-disk result cache rooted at dir, bounded to maxSize bytes (<= 0
+// for unbounded). Passing a cache makes run() and runReusable() skip the executable entirely on
+// a hit, without acquiring a pool slot.
+func (proc *concurrentProcess) setCache(dir string, maxSize int64) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("could not create cache directory %s: %w", dir, err)
+	}
+	proc.cache = newResultCache(dir, maxSize)
+	return nil
+}
+
+// runWithCache serves (exe, args, stdin) from proc's result cache when possible, invoking
+// callback synchronously without going through dispatch or acquiring a pool slot. On a miss, it
+// calls dispatch with a wrapped callback that stores the eventual successful result before
+// forwarding it to callback. When proc has no cache configured, it just calls dispatch with
+// callback unchanged.
+//
+// A cache hit is still subject to proc's cancellation: once proc.ctx is done (Cancel/Shutdown
+// was called), callback receives proc.ctx's error instead of the cached result, as it does from
+// the normal dispatch path once the pool semaphore's Acquire starts failing. An error returned
+// by callback on a hit is reported by wait/Shutdown like any other callback error.
+func (proc *concurrentProcess) runWithCache(exe string, args []string, stdin string, callback func([]byte, error) error, dispatch func(cb func([]byte, error) error)) {
+	if proc.cache == nil {
+		dispatch(callback)
+		return
+	}
+
+	if stdout, ok := proc.cache.lookup(exe, args, stdin); ok {
+		err := proc.ctx.Err()
+		if err != nil {
+			stdout = nil
+		}
+		if cerr := callback(stdout, err); cerr != nil {
+			proc.eg.Go(func() error { return cerr })
+		}
+		return
+	}
+
+	cache := proc.cache
+	dispatch(func(stdout []byte, runErr error) error {
+		cache.store(exe, args, stdin, stdout, runErr)
+		return callback(stdout, runErr)
+	})
+}
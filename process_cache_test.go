@@ -0,0 +1,69 @@
+package actionlint
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResultCacheHitAndMiss(t *testing.T) {
+	dir := t.TempDir()
+	c := newResultCache(dir, 0)
+	exe := "/bin/echo"
+
+	if _, ok := c.lookup(exe, []string{"a"}, "in"); ok {
+		t.Fatalf("expected a miss before anything is stored")
+	}
+
+	c.store(exe, []string{"a"}, "in", []byte("out"), nil)
+
+	stdout, ok := c.lookup(exe, []string{"a"}, "in")
+	if !ok {
+		t.Fatalf("expected a hit after store")
+	}
+	if string(stdout) != "out" {
+		t.Fatalf("got %q, want %q", stdout, "out")
+	}
+
+	if _, ok := c.lookup(exe, []string{"b"}, "in"); ok {
+		t.Fatalf("different args should not hit the same entry")
+	}
+}
+
+func TestResultCacheDoesNotCacheErrors(t *testing.T) {
+	dir := t.TempDir()
+	c := newResultCache(dir, 0)
+	exe := "/bin/echo"
+
+	c.store(exe, nil, "in", nil, errors.New("boom"))
+
+	if _, ok := c.lookup(exe, nil, "in"); ok {
+		t.Fatalf("a failed run must not be served from the cache on a later call")
+	}
+}
+
+func TestResultCacheEvictsLeastRecentlyUsedOverBudget(t *testing.T) {
+	dir := t.TempDir()
+	exe := "/bin/echo"
+	// Each entry is a handful of bytes of JSON; budget for roughly one entry so the second
+	// store forces an eviction.
+	c := newResultCache(dir, 40)
+
+	c.store(exe, []string{"1"}, "in", []byte("aaaaaaaaaa"), nil)
+	c.store(exe, []string{"2"}, "in", []byte("bbbbbbbbbb"), nil)
+
+	remaining := 0
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			remaining++
+		}
+		return nil
+	})
+	if remaining == 0 {
+		t.Fatalf("expected at least one entry to survive eviction")
+	}
+	if remaining >= 2 {
+		t.Fatalf("expected eviction to have removed at least one entry once over budget, found %d files on disk", remaining)
+	}
+}
@@ -0,0 +1,128 @@
+package actionlint
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConcurrentProcessCancelStopsNewWork(t *testing.T) {
+	proc := newConcurrentProcess(context.Background(), 1, nil, 0)
+	proc.Cancel()
+
+	// A call submitted after Cancel still reaches the callback (the pool semaphore may grant a
+	// free slot before noticing ctx is done), but the process itself must never actually start:
+	// the call should fail immediately with a context error instead of running echo.
+	result := make(chan error, 1)
+	proc.run("echo", "/bin/echo", nil, "", func(stdout []byte, err error) error {
+		result <- err
+		return nil
+	})
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatalf("expected an error for a call submitted after Cancel, got nil")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("callback was not invoked for a call submitted after Cancel")
+	}
+	if err := proc.wait(); err != nil {
+		t.Fatalf("wait returned error: %v", err)
+	}
+}
+
+func TestConcurrentProcessPerInvocationTimeout(t *testing.T) {
+	proc := newConcurrentProcess(context.Background(), 1, nil, 50*time.Millisecond)
+
+	result := make(chan error, 1)
+	proc.run("sleep", "/bin/sleep", []string{"2"}, "", func(stdout []byte, err error) error {
+		result <- err
+		return nil
+	})
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Fatalf("expected an error from a process that outlived its timeout")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("callback was not invoked within the timeout budget; the per-invocation timeout was not enforced")
+	}
+
+	if err := proc.Shutdown(); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+}
+
+func TestRunProcessWithStdinCapsOutput(t *testing.T) {
+	_, err := runProcessWithStdin(context.Background(), "/bin/sh", []string{"-c", "head -c 1024 /dev/zero"}, "", 16)
+	if err == nil {
+		t.Fatalf("expected an error when output exceeds maxOutputBytes")
+	}
+	if !strings.Contains(err.Error(), "produced more than") {
+		t.Fatalf("error should mention the output cap, got: %v", err)
+	}
+}
+
+func TestRunProcessWithStdinStreamsStdinAndStdout(t *testing.T) {
+	stdout, err := runProcessWithStdin(context.Background(), "/bin/cat", nil, "hello, world", 1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(stdout) != "hello, world" {
+		t.Fatalf("got %q, want %q", stdout, "hello, world")
+	}
+}
+
+func TestConcurrentProcessPoolsAreIndependent(t *testing.T) {
+	proc := newConcurrentProcess(context.Background(), 1, map[string]int{"slow": 1, "fast": 1}, 0)
+
+	blocked := make(chan struct{})
+	proc.run("slow", "/bin/sh", []string{"-c", "sleep 5"}, "", func(stdout []byte, err error) error {
+		close(blocked)
+		return nil
+	})
+
+	fastDone := make(chan error, 1)
+	proc.run("fast", "/bin/echo", nil, "", func(stdout []byte, err error) error {
+		fastDone <- err
+		return nil
+	})
+
+	select {
+	case err := <-fastDone:
+		if err != nil {
+			t.Fatalf("unexpected error from fast pool: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("a busy 'slow' pool blocked the independent 'fast' pool")
+	}
+
+	select {
+	case <-blocked:
+		t.Fatalf("slow job finished unexpectedly early; test is no longer exercising pool independence")
+	default:
+	}
+
+	proc.Cancel()
+	proc.wait()
+}
+
+func TestRunSerialWithRetryDoesNotSleepAfterFinalAttempt(t *testing.T) {
+	proc := newConcurrentProcess(context.Background(), 1, nil, 0)
+	proc.setTransientErrorChecker(func(stdout []byte, err error) bool { return err != nil })
+
+	start := time.Now()
+	_, err := proc.runSerialWithRetry("./does-not-exist-actionlint-test-binary", nil, "")
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("expected an error for a nonexistent executable")
+	}
+	// maxTransientRetries=3 sleeps at most twice (after attempts 0 and 1, backoff 200ms+400ms=600ms).
+	// A regression that also sleeps after the final attempt would add another 800ms, crossing this.
+	if elapsed > 1100*time.Millisecond {
+		t.Fatalf("runSerialWithRetry took %v; it looks like it slept after the final attempt", elapsed)
+	}
+}
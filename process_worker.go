@@ -0,0 +1,330 @@
+package actionlint
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+)
+
+// workerScriptInterpreter runs the embedded shellcheck-worker.py dispatcher. Python's stdlib
+// json module is what makes the framed protocol safe to round-trip; see shellcheck-worker.py.
+const workerScriptInterpreter = "python3"
+
+// shellcheckWorkerScript is a tiny dispatcher process shipped alongside actionlint. It wraps a
+// shellcheck executable that has no REPL mode of its own, reading one framed request at a time
+// from stdin and re-invoking shellcheck internally with that request's own args for each one.
+// See shellcheck-worker.py for the protocol it speaks.
+//
+//go:embed shellcheck-worker.py
+var shellcheckWorkerScript []byte
+
+// workerRequest is one frame sent to a persistent worker process. Args and Stdin are exactly what
+// a one-shot invocation of the wrapped executable would have received as its arguments and its
+// own stdin, so a worker-backed call and a one-shot call of the same (args, stdin) always produce
+// the same result.
+type workerRequest struct {
+	ID    string   `json:"id"`
+	Args  []string `json:"args"`
+	Stdin string   `json:"stdin"`
+}
+
+// workerResponse is one frame read back from a persistent worker process, correlated to its
+// request by ID. worker.do treats a response whose ID differs from its request's as a protocol
+// error and marks the worker dead; replies are not reordered or matched up later.
+type workerResponse struct {
+	ID       string `json:"id"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+	Error    string `json:"error,omitempty"`
+}
+
+// writeFrame writes payload prefixed with its big-endian uint32 length. This length-prefixed
+// framing is the wire protocol the persistent worker speaks.
+func writeFrame(w io.Writer, payload []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(payload)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// marshalWorkerJSON encodes v like json.Marshal, but with HTML-escaping of &, < and > turned
+// off. The worker protocol isn't HTML, so script bodies containing shell operators like "&&" or
+// output redirection are written as-is rather than as \uXXXX sequences.
+func marshalWorkerJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// readFrame reads one length-prefixed frame written by writeFrame.
+func readFrame(r io.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// worker is one long-lived child process dispatched requests over the framed JSON protocol on
+// its stdin/stdout, avoiding the fork+exec+load cost of spawning a fresh process per call.
+type worker struct {
+	mu     sync.Mutex // serializes request/response pairs; the protocol handles one request at a time
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	cmd    *exec.Cmd
+	dead   bool
+}
+
+func startWorker(ctx context.Context, exe string, args []string) (*worker, error) {
+	cmd := exec.CommandContext(ctx, exe, args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not make stdin pipe for worker %s: %w", exe, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not make stdout pipe for worker %s: %w", exe, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start worker %s: %w", exe, err)
+	}
+	return &worker{stdin: stdin, stdout: bufio.NewReader(stdout), cmd: cmd}, nil
+}
+
+// do sends req to the worker and waits for its matching response. Safe to call concurrently;
+// concurrent calls are serialized since the wire protocol is not pipelined.
+func (w *worker) do(req workerRequest) (workerResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var resp workerResponse
+	if w.dead {
+		return resp, fmt.Errorf("worker process has exited")
+	}
+
+	payload, err := marshalWorkerJSON(req)
+	if err != nil {
+		return resp, fmt.Errorf("could not encode worker request: %w", err)
+	}
+	if err := writeFrame(w.stdin, payload); err != nil {
+		w.dead = true
+		return resp, fmt.Errorf("could not write request to worker: %w", err)
+	}
+	frame, err := readFrame(w.stdout)
+	if err != nil {
+		w.dead = true
+		return resp, fmt.Errorf("could not read response from worker: %w", err)
+	}
+	if err := json.Unmarshal(frame, &resp); err != nil {
+		w.dead = true
+		return resp, fmt.Errorf("could not decode worker response: %w", err)
+	}
+	if resp.ID != req.ID {
+		w.dead = true
+		return resp, fmt.Errorf("worker response id %q does not match request id %q", resp.ID, req.ID)
+	}
+	return resp, nil
+}
+
+// workerPool dispatches requests across a fixed set of persistent workers, round-robin. exe and
+// args here are the worker *process's* own startup command (e.g. the dispatcher script plus the
+// wrapped executable's path) and are kept around so a worker that dies can be respawned in place;
+// they are unrelated to the per-call args passed to dispatch, which are forwarded to the wrapped
+// executable for that one request.
+type workerPool struct {
+	ctx  context.Context
+	exe  string
+	args []string
+
+	mu      sync.Mutex // guards workers; replaced in place by respawn
+	workers []*worker
+
+	respawning []int32 // atomic per-slot flag; prevents a burst of failures from racing several respawns of the same slot
+
+	next  uint64
+	reqID uint64
+}
+
+func newWorkerPool(ctx context.Context, exe string, args []string, n int) (*workerPool, error) {
+	wp := &workerPool{ctx: ctx, exe: exe, args: args, respawning: make([]int32, n)}
+	for i := 0; i < n; i++ {
+		w, err := startWorker(ctx, exe, args)
+		if err != nil {
+			return nil, err
+		}
+		wp.workers = append(wp.workers, w)
+	}
+	return wp, nil
+}
+
+func (wp *workerPool) worker(i int) *worker {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return wp.workers[i]
+}
+
+// respawn replaces the worker at index i with a freshly started one. It's called in the
+// background after a worker is found dead, so the *next* call to that slot gets a working
+// worker instead of the pool staying broken for the rest of the process's lifetime. If
+// restarting fails (e.g. the executable disappeared), the dead worker is left in place and
+// dispatch keeps falling back to the one-shot path for it.
+//
+// A burst of concurrent failures against the same slot would otherwise all trigger their own
+// respawn, racing to install a replacement while the losers leak until proc.ctx is canceled; the
+// per-slot respawning flag lets only one respawn run at a time for a given slot.
+func (wp *workerPool) respawn(i int) {
+	if !atomic.CompareAndSwapInt32(&wp.respawning[i], 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&wp.respawning[i], 0)
+
+	w, err := startWorker(wp.ctx, wp.exe, wp.args)
+	if err != nil {
+		return
+	}
+	wp.mu.Lock()
+	wp.workers[i] = w
+	wp.mu.Unlock()
+}
+
+// dispatch runs args/stdin through one of the pool's workers, exactly as a one-shot invocation of
+// the wrapped executable with the same args and stdin would, and returns a (stdout, error) pair
+// usable directly as the arguments to a run() callback, plus whether the failure means the
+// worker itself is unusable (as opposed to e.g. shellcheck finding issues). Callers should treat
+// workerDied as a signal to fall back to the regular fork-per-call path for this one call; a
+// respawn of the affected worker is already underway in the background.
+func (wp *workerPool) dispatch(args []string, stdin string) (stdout []byte, err error, workerDied bool) {
+	i := int(atomic.AddUint64(&wp.next, 1) % uint64(len(wp.workers)))
+	w := wp.worker(i)
+
+	id := fmt.Sprintf("%d", atomic.AddUint64(&wp.reqID, 1))
+	resp, doErr := w.do(workerRequest{ID: id, Args: args, Stdin: stdin})
+	if doErr != nil {
+		go wp.respawn(i)
+		return nil, doErr, true
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("%s", resp.Error), false
+	}
+	out := []byte(resp.Stdout)
+	if resp.ExitCode != 0 && len(out) == 0 {
+		return nil, fmt.Errorf("%s exited with status %d but stdout was empty. stderr: %q", wp.exe, resp.ExitCode, resp.Stderr), false
+	}
+	return out, nil, false
+}
+
+// enableWorkerPool tries to start n persistent workers running exe with args for poolName, to
+// back subsequent runReusable calls against that pool. If exe can't be turned into a worker
+// (e.g. it isn't present, or spawning failed), this is a no-op and runReusable keeps using the
+// regular fork-per-call path for poolName.
+func (proc *concurrentProcess) enableWorkerPool(poolName, exe string, args []string, n int) {
+	wp, err := newWorkerPool(proc.ctx, exe, args, n)
+	if err != nil {
+		return
+	}
+	proc.workerMu.Lock()
+	defer proc.workerMu.Unlock()
+	if proc.workerPools == nil {
+		proc.workerPools = make(map[string]*workerPool)
+	}
+	proc.workerPools[poolName] = wp
+}
+
+// enableShellcheckWorkers starts n persistent shellcheck-worker.py processes wrapping
+// shellcheckExe, so repeated small `run:` steps are sent to a handful of long-lived dispatcher
+// processes. Each dispatcher still starts shellcheck afresh for every request, since shellcheck
+// has no REPL mode of its own. It is a no-op if the dispatcher script can't be written
+// to disk or started, leaving runReusable to fall back to fork-per-call for the shellcheck pool.
+//
+// The script is written to a temp file (workers respawned later by the pool need to be able to
+// re-read it, so it can't be removed right after starting them); it's cleaned up once proc.ctx
+// is done, which is also when no further respawn can happen.
+func (proc *concurrentProcess) enableShellcheckWorkers(shellcheckExe string, n int) {
+	script, err := writeShellcheckWorkerScript()
+	if err != nil {
+		return
+	}
+
+	wp, err := newWorkerPool(proc.ctx, workerScriptInterpreter, []string{script, shellcheckExe}, n)
+	if err != nil {
+		os.Remove(script)
+		return
+	}
+
+	proc.workerMu.Lock()
+	if proc.workerPools == nil {
+		proc.workerPools = make(map[string]*workerPool)
+	}
+	proc.workerPools[shellcheckPoolName] = wp
+	proc.workerMu.Unlock()
+
+	go func() {
+		<-proc.ctx.Done()
+		os.Remove(script)
+	}()
+}
+
+func writeShellcheckWorkerScript() (string, error) {
+	f, err := os.CreateTemp("", "actionlint-shellcheck-worker-*.py")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(shellcheckWorkerScript); err != nil {
+		return "", err
+	}
+	return f.Name(), f.Chmod(0o755)
+}
+
+func (proc *concurrentProcess) workerPoolFor(poolName string) *workerPool {
+	proc.workerMu.Lock()
+	defer proc.workerMu.Unlock()
+	return proc.workerPools[poolName]
+}
+
+// runReusable behaves like run, but transparently dispatches to a persistent worker pool set up
+// via enableWorkerPool when one is available for poolName, instead of paying fork+exec+load for
+// every call. Callers can't tell the difference: the callback contract, including the result
+// cache lookup described on run, is identical to run's.
+//
+// If the worker used for a call turns out to be dead, this call falls back to running exe
+// directly through the regular pool rather than surfacing the error, so one dead worker doesn't
+// break linting against poolName for the rest of the process's lifetime; the pool respawns the
+// worker in the background for subsequent calls.
+func (proc *concurrentProcess) runReusable(poolName string, exe string, args []string, stdin string, callback func([]byte, error) error) {
+	proc.runWithCache(exe, args, stdin, callback, func(cb func([]byte, error) error) {
+		if wp := proc.workerPoolFor(poolName); wp != nil {
+			proc.eg.Go(func() error {
+				stdout, err, workerDied := wp.dispatch(args, stdin)
+				if workerDied {
+					proc.pool(poolName).run(exe, args, stdin, cb)
+					return nil
+				}
+				return cb(stdout, err)
+			})
+			return
+		}
+		proc.pool(poolName).run(exe, args, stdin, cb)
+	})
+}
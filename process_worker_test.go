@@ -0,0 +1,151 @@
+package actionlint
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeShellcheckScript stands in for shellcheck: it echoes stdin back on stdout, echoes its argv
+// on stderr (so tests can verify which args a request actually reached it with), and exits 1, the
+// way real shellcheck does when it finds issues.
+const fakeShellcheckScript = `#!/usr/bin/env python3
+import sys
+data = sys.stdin.buffer.read()
+sys.stderr.write(" ".join(sys.argv[1:]))
+sys.stdout.buffer.write(data)
+sys.exit(1)
+`
+
+// writeFakeShellcheck writes a stand-in "shellcheck" that echoes its stdin back and exits 1, the
+// way real shellcheck does when it finds issues, without depending on shellcheck being installed.
+func writeFakeShellcheck(t *testing.T) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "fake-shellcheck-*.py")
+	if err != nil {
+		t.Fatalf("could not create fake shellcheck: %v", err)
+	}
+	if _, err := f.WriteString(fakeShellcheckScript); err != nil {
+		t.Fatalf("could not write fake shellcheck: %v", err)
+	}
+	f.Close()
+	if err := os.Chmod(f.Name(), 0o755); err != nil {
+		t.Fatalf("could not chmod fake shellcheck: %v", err)
+	}
+	return f.Name()
+}
+
+func requirePython3(t *testing.T) string {
+	t.Helper()
+	python3, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found, skipping persistent worker test")
+	}
+	return python3
+}
+
+func newTestWorkerPool(t *testing.T) *workerPool {
+	t.Helper()
+	python3 := requirePython3(t)
+	fake := writeFakeShellcheck(t)
+
+	script, err := writeShellcheckWorkerScript()
+	if err != nil {
+		t.Fatalf("writeShellcheckWorkerScript: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(script) })
+
+	wp, err := newWorkerPool(context.Background(), python3, []string{script, fake}, 1)
+	if err != nil {
+		t.Fatalf("newWorkerPool: %v", err)
+	}
+	return wp
+}
+
+var fakeShellcheckArgs = []string{"-f", "json", "-"}
+
+// TestWorkerPoolDispatchRoundTripsShellOperatorsAndNonZeroExit guards against two failure modes
+// of a shell-based dispatcher (sed-based JSON handling and `set -e`): a request whose stdin
+// contains shell operators like "&&" or ">" must come back byte-for-byte, and a non-zero exit
+// status from the wrapped tool (the normal case when it finds issues) must not kill the worker.
+func TestWorkerPoolDispatchRoundTripsShellOperatorsAndNonZeroExit(t *testing.T) {
+	wp := newTestWorkerPool(t)
+
+	stdin := "echo a && echo b > out.txt\n"
+	stdout, err, died := wp.dispatch(fakeShellcheckArgs, stdin)
+	if died {
+		t.Fatalf("worker died on a request that should have just returned a non-zero exit")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(stdout) != stdin {
+		t.Fatalf("stdin was not round-tripped correctly: got %q, want %q", stdout, stdin)
+	}
+
+	// A second call against the same worker proves it's still alive after the non-zero exit.
+	stdout, err, died = wp.dispatch(fakeShellcheckArgs, stdin)
+	if died || err != nil {
+		t.Fatalf("worker should still be usable after a non-zero exit, got err=%v died=%v", err, died)
+	}
+	if string(stdout) != stdin {
+		t.Fatalf("stdin was not round-tripped correctly on second call: got %q", stdout)
+	}
+}
+
+// TestWorkerPoolDispatchForwardsCallArgs guards against worker mode silently dropping a call's
+// args: the wrapped executable must see exactly the args this particular call passed, not some
+// fixed set baked into the dispatcher, since otherwise the same call could lint differently
+// depending on whether a worker happened to be warm.
+func TestWorkerPoolDispatchForwardsCallArgs(t *testing.T) {
+	wp := newTestWorkerPool(t)
+
+	args := []string{"--severity", "warning", "-"}
+	resp, err := wp.worker(0).do(workerRequest{ID: "probe", Args: args, Stdin: "x\n"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Stderr != strings.Join(args, " ") {
+		t.Fatalf("worker ran with argv %q, want %q", resp.Stderr, strings.Join(args, " "))
+	}
+}
+
+func TestWorkerPoolRespawnsAfterWorkerDies(t *testing.T) {
+	wp := newTestWorkerPool(t)
+
+	w := wp.worker(0)
+	if err := w.cmd.Process.Kill(); err != nil {
+		t.Fatalf("could not kill worker process: %v", err)
+	}
+	w.cmd.Wait()
+
+	stdin := "echo hi\n"
+	if _, _, died := wp.dispatch(fakeShellcheckArgs, stdin); !died {
+		t.Fatalf("expected dispatch to report the killed worker as dead")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stdout, err, died := wp.dispatch(fakeShellcheckArgs, stdin)
+		if !died && err == nil && string(stdout) == stdin {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker pool never recovered after respawn (last err=%v died=%v)", err, died)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestMarshalWorkerJSONDoesNotHTMLEscape(t *testing.T) {
+	payload, err := marshalWorkerJSON(workerRequest{ID: "1", Stdin: "echo a && echo b > out.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(payload), "echo a && echo b > out.txt") {
+		t.Fatalf("worker request JSON should preserve literal shell operators instead of HTML-escaping them, got: %s", payload)
+	}
+}